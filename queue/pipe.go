@@ -0,0 +1,39 @@
+package queue
+
+import (
+	"iter"
+)
+
+// Pipe pushes all events received by src into dst, returning once src becomes invalid or dst is closed.
+// A closed dst is noticed when the next events arrive from src.
+// Returns the reason src became invalid as per Listener.Err, or the error dst was closed with.
+func Pipe[X any](dst Queue[X], src Listener[X]) error {
+	for {
+		batch := src.Batch()
+		if len(batch) == 0 {
+			return src.Err()
+		}
+		if !dst.Push(batch...) {
+			if err := dst.Err(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// PushSeq pushes each event from seq into q as it is yielded, returning once seq ends or q is closed.
+// Returns the number of events accepted while q was open; events dropped by a closed q are not counted.
+func PushSeq[X any](q Queue[X], seq iter.Seq[X]) int {
+	var count int
+	if q.Err() != nil {
+		return count
+	}
+	for x := range seq {
+		q.Push(x)
+		if q.Err() != nil {
+			break // closed before or during this push, don't wait for more
+		}
+		count++
+	}
+	return count
+}
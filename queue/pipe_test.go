@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	src := New[int]()
+	dst := New[int]()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := dst.Join(ctx)
+
+	srcL := src.Join(ctx)
+	errCh := make(chan error)
+	go func() {
+		errCh <- Pipe(dst, srcL)
+	}()
+
+	if count := PushSeq(src, slices.Values([]int{1, 2, 3})); count != 3 {
+		t.Errorf("expected 3 pushed, was: %v", count)
+	}
+
+	var out []int
+	for len(out) < 3 {
+		out = append(out, l.Batch()...)
+	}
+	if !reflect.DeepEqual(out, []int{1, 2, 3}) {
+		t.Errorf("expected 1,2,3, was: %+v", out)
+	}
+
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Errorf("expected pipe to end with context.Canceled, was: %v", err)
+	}
+}
+
+func TestPipeClosed(t *testing.T) {
+	src := New[int]()
+	dst := New[int]()
+
+	srcL := src.Join(context.Background())
+	errCh := make(chan error)
+	go func() {
+		errCh <- Pipe(dst, srcL)
+	}()
+
+	dst.Close(nil)
+	src.Push(1)
+	if err := <-errCh; err != ErrClosed {
+		t.Errorf("expected pipe to end with ErrClosed, was: %v", err)
+	}
+}
+
+func TestPushSeqClosed(t *testing.T) {
+	q := New[int]()
+	q.Close(nil)
+
+	if count := PushSeq(q, slices.Values([]int{1, 2, 3})); count != 0 {
+		t.Errorf("expected 0 pushed to closed queue, was: %v", count)
+	}
+
+	// stops on close even if seq never ends
+	q = New[int]()
+	count := PushSeq(q, func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			if i == 2 {
+				q.Close(nil)
+			}
+			if !yield(i) {
+				return
+			}
+		}
+	})
+	if count != 2 {
+		t.Errorf("expected 2 pushed before close, was: %v", count)
+	}
+}
@@ -2,25 +2,78 @@ package queue
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"sync"
+	"time"
+)
+
+var (
+	ErrClosed  = errors.New("queue closed")
+	ErrEvicted = errors.New("queue listener evicted")
 )
 
 // New builds a new concurrent broadcast queue.
 func New[X any]() Queue[X] {
+	return NewConfig(Config[X]{})
+}
+
+// NewConfig builds a new concurrent broadcast queue with the given Config.
+func NewConfig[X any](c Config[X]) Queue[X] {
+	return newQueue[X](c)
+}
+
+// NewCoalesce builds a new concurrent broadcast queue where pending events with the same key collapse.
+// Listeners only see the latest event for each key that they have not yet consumed, in the position it was last pushed.
+func NewCoalesce[K comparable, X any](c Config[X], key func(X) K) Queue[X] {
+	q := newQueue[X](c)
+	q.key = func(x X) any { return key(x) }
+	return q
+}
+
+func newQueue[X any](c Config[X]) *queueImpl[X] {
 	return &queueImpl[X]{
-		subs: make(map[int]int),
-		cond: sync.NewCond(&sync.Mutex{}),
+		subs:    make(map[*queueSub[X]]struct{}),
+		cond:    sync.NewCond(&sync.Mutex{}),
+		history: max(c.History, 0),
+		maxLag:  max(c.MaxLag, 0),
+		onEvict: c.OnEvict,
 	}
 }
 
 type queueImpl[X any] struct {
-	head   int
-	events []X
-	subs   map[int]int
+	head    int
+	pushed  int // total passed to Push, head may be lower due to coalescing
+	low     int // position of slowest listener at last trim
+	events  []X
+	subs    map[*queueSub[X]]struct{}
+	history int
+	closed  error
+	key     func(X) any // for coalescing
+	maxLag  int
+	onEvict func(l Listener[X], lag int)
 
 	cond *sync.Cond
+}
+
+// queueSub is the state of a single listener, guarded by the queue lock.
+type queueSub[X any] struct {
+	pos    int              // position of next event
+	urgent []urgentEvent[X] // private priority events, highest first
+	err    error            // set once removed
+	stop   func() bool      // stops the context.AfterFunc
 
-	observerHigh int
+	listener *queueListener[X] // returned from Join
+}
+
+type evictedListener[X any] struct {
+	l   *queueListener[X]
+	lag int
+}
+
+type urgentEvent[X any] struct {
+	priority int
+	x        X
 }
 
 func (q *queueImpl[X]) Push(all ...X) bool {
@@ -28,17 +81,36 @@ func (q *queueImpl[X]) Push(all ...X) bool {
 		return false // broadcast would be wasteful
 	}
 
+	var evicted []evictedListener[X]
+	defer func() { q.notifyEvicted(evicted) }() // runs after unlock
+
 	q.cond.L.Lock()
 	defer q.cond.L.Unlock()
 
+	if q.closed != nil {
+		return false
+	}
+
+	// check lag before this push, so a large push doesn't evict caught-up listeners
+	evicted = q.evictSlow()
+
+	q.pushed += len(all)
+
+	if q.key != nil {
+		all = q.dedupe(all)
+		for _, x := range all {
+			q.coalesceEvent(x)
+		}
+	}
+
 	q.head += len(all)
+	q.events = append(q.events, all...)
 
 	if len(q.subs) == 0 {
-		q.events = nil
-		return false // we can literally drop all, noone cares
+		q.trimEvents() // drop all but history, noone cares
+		return false
 	}
 
-	q.events = append(q.events, all...)
 	q.cond.Broadcast()
 
 	// we have the lock again, can now check who broadcast stuff and trim events
@@ -46,76 +118,292 @@ func (q *queueImpl[X]) Push(all ...X) bool {
 	return q.trimEvents()
 }
 
-func (q *queueImpl[X]) Join(ctx context.Context) Listener[X] {
+func (q *queueImpl[X]) PushPriority(priority int, all ...X) bool {
+	if priority <= 0 {
+		return q.Push(all...)
+	} else if len(all) == 0 {
+		return false
+	}
+
+	var evicted []evictedListener[X]
+	defer func() { q.notifyEvicted(evicted) }() // runs after unlock
+
 	q.cond.L.Lock()
 	defer q.cond.L.Unlock()
 
-	who := q.observerHigh
-	q.observerHigh++
+	if q.closed != nil {
+		return false
+	}
 
-	go func() {
-		<-ctx.Done()
+	evicted = q.evictSlow()
+	if len(evicted) != 0 {
+		q.trimEvents() // drop backlog of evicted listeners
+	}
+	if len(q.subs) == 0 {
+		return false
+	}
+
+	add := make([]urgentEvent[X], len(all))
+	for i, x := range all {
+		add[i] = urgentEvent[X]{priority: priority, x: x}
+	}
+
+	for sub := range q.subs {
+		// insert after all events of the same or higher priority
+		i := len(sub.urgent)
+		for j, u := range sub.urgent {
+			if u.priority < priority {
+				i = j
+				break
+			}
+		}
+		sub.urgent = slices.Insert(sub.urgent, i, add...)
+	}
+
+	q.cond.Broadcast()
+	return true
+}
+
+func (q *queueImpl[X]) Join(ctx context.Context) Listener[X] {
+	return q.join(ctx, 0)
+}
+
+func (q *queueImpl[X]) JoinWithReplay(ctx context.Context, n int) Listener[X] {
+	return q.join(ctx, n)
+}
+
+func (q *queueImpl[X]) join(ctx context.Context, replay int) *queueListener[X] {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	sub := &queueSub[X]{}
+	sub.listener = &queueListener[X]{q: q, sub: sub}
+	if q.closed != nil {
+		sub.err = q.closed
+		return sub.listener
+	}
 
+	sub.stop = context.AfterFunc(ctx, func() {
 		q.cond.L.Lock()
 		defer q.cond.L.Unlock()
 
-		delete(q.subs, who)
+		if !q.remove(sub, context.Cause(ctx)) {
+			return
+		}
 		q.trimEvents() // we can purge events
 
 		// wake up everyone
 		// TODO: bad for large numbers of queue listeners, they all have to check if they're evicted
 		q.cond.Broadcast()
-	}()
+	})
+
+	replay = min(max(replay, 0), len(q.events))
+	sub.pos = q.head - replay
+	q.subs[sub] = struct{}{}
+
+	return sub.listener
+}
+
+func (q *queueImpl[X]) Close(err error) bool {
+	if err == nil {
+		err = ErrClosed
+	}
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
 
-	q.subs[who] = q.head
+	if q.closed != nil {
+		return false
+	}
+	q.closed = err
 
-	return &queueListener[X]{q: q, who: who}
+	for sub := range q.subs {
+		sub.stop()
+		q.remove(sub, err)
+	}
+	q.events = nil
+	q.cond.Broadcast()
+	return true
+}
+
+// dedupe returns all with only the last event for each key, in order.
+// It does not modify all, which may belong to the caller.
+func (q *queueImpl[X]) dedupe(all []X) []X {
+	seen := make(map[any]struct{}, len(all))
+	out := make([]X, 0, len(all))
+	for i := len(all) - 1; i >= 0; i-- {
+		k := q.key(all[i])
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		out = append(out, all[i])
+	}
+	slices.Reverse(out)
+	return out
+}
+
+// coalesceEvent must be called under lock. It removes any retained event with the same key as x.
+func (q *queueImpl[X]) coalesceEvent(x X) {
+	// TODO: O(n) in retained events, could track by key
+	k := q.key(x)
+	for i, cand := range q.events {
+		if q.key(cand) != k {
+			continue
+		}
+
+		// copy: listeners may hold slices from Batch
+		q.events = append(q.events[:i:i], q.events[i+1:]...)
+		q.head--
+
+		// listeners past this event have consumed it, shift them back
+		p := q.head - len(q.events) + i
+		for sub := range q.subs {
+			if sub.pos > p {
+				sub.pos--
+			}
+		}
+		if q.low > p {
+			q.low--
+		}
+		return // only ever one retained per key
+	}
+}
+
+func (q *queueImpl[X]) Err() error {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return q.closed
+}
+
+// remove must be called under lock. Returns false if the sub was already removed.
+func (q *queueImpl[X]) remove(sub *queueSub[X], err error) bool {
+	if sub.err != nil {
+		return false
+	}
+	sub.err = err
+	sub.urgent = nil
+	delete(q.subs, sub)
+	return true
+}
+
+// evictSlow must be called under lock. It evicts listeners with more than maxLag pending events.
+func (q *queueImpl[X]) evictSlow() []evictedListener[X] {
+	if q.maxLag <= 0 {
+		return nil
+	}
+	var evicted []evictedListener[X]
+	for sub := range q.subs {
+		if lag := q.lag(sub); lag > q.maxLag {
+			sub.stop()
+			q.remove(sub, ErrEvicted)
+			evicted = append(evicted, evictedListener[X]{l: sub.listener, lag: lag})
+		}
+	}
+	return evicted
+}
+
+// notifyEvicted calls onEvict for each evicted listener. It must not be called under lock.
+func (q *queueImpl[X]) notifyEvicted(evicted []evictedListener[X]) {
+	if q.onEvict == nil {
+		return
+	}
+	for _, e := range evicted {
+		q.onEvict(e.l, e.lag)
+	}
+}
+
+// lag must be called under lock.
+func (q *queueImpl[X]) lag(sub *queueSub[X]) int {
+	if sub.err != nil {
+		return 0
+	}
+	return q.head - sub.pos + len(sub.urgent)
+}
+
+func (q *queueImpl[X]) Stats() Stats {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	s := Stats{
+		Head:      q.pushed,
+		Retained:  len(q.events),
+		Listeners: len(q.subs),
+	}
+	for sub := range q.subs {
+		s.MaxLag = max(s.MaxLag, q.lag(sub))
+	}
+	return s
 }
 
 // trimEvents must be called under lock.
+// Returns true if the slowest listener has consumed events since the last call.
 func (q *queueImpl[X]) trimEvents() bool {
-	// we have the lock again, can now check who broadcast stuff and trim events
 	// TODO: "slow" for large numbers of subs (O(n))
 	m := q.head
-	for _, cand := range q.subs {
-		m = min(cand, m)
+	for sub := range q.subs {
+		m = min(sub.pos, m)
 	}
-	if m == q.head {
-		if len(q.events) > 0 {
-			q.events = nil
-			return true // we always had at least one event, someone consumed it
-		}
-		return false
+	advanced := m > q.low
+	q.low = m
+
+	// keep everything someone hasn't seen, or our history
+	keep := max(q.head-m, min(q.history, len(q.events)))
+	if keep == 0 {
+		q.events = nil
+	} else if keep < len(q.events) {
+		q.events = q.events[len(q.events)-keep:]
 	}
+	return advanced
+}
 
-	start := q.head - len(q.events)
-	strip := m - start
-	if strip > 0 {
-		q.events = q.events[strip:]
-		return true // someone consumed an event
+// pending returns the events not yet consumed by the given listener.
+// If there are any priority events, only those are returned.
+// It must be called under lock.
+func (q *queueImpl[X]) pending(sub *queueSub[X]) []X {
+	if sub.err != nil {
+		return nil
+	} else if len(sub.urgent) != 0 {
+		out := make([]X, len(sub.urgent))
+		for i, u := range sub.urgent {
+			out[i] = u.x
+		}
+		return out
 	}
-	return false
+	start := q.head - len(q.events)
+	return q.events[sub.pos-start:]
 }
 
-func (q *queueImpl[X]) wait(who int, handler func(avail []X) int) bool {
+func (q *queueImpl[X]) wait(ctx context.Context, sub *queueSub[X], handler func(avail []X) int) bool {
+	if ctx.Done() != nil {
+		// wake everyone if this ctx is done, so we can notice below
+		stop := context.AfterFunc(ctx, func() {
+			q.cond.L.Lock()
+			defer q.cond.L.Unlock()
+			q.cond.Broadcast()
+		})
+		defer stop()
+	}
+
 	q.cond.L.Lock()
 	defer q.cond.L.Unlock()
 
 	for {
-		last, ok := q.subs[who]
-		if !ok {
-			// either wrong, OR we got done for
+		if sub.err != nil {
+			// we got done for
 			return false
 		}
 
-		if last == q.head {
+		if sub.pos == q.head && len(sub.urgent) == 0 {
+			if ctx.Err() != nil {
+				return false
+			}
 			q.cond.Wait()
 			continue
 		}
 
-		start := q.head - len(q.events)
-		skip := last - start
-		toSend := q.events[skip:]
+		toSend := q.pending(sub)
 
 		consumed := handler(toSend)
 		if consumed <= 0 {
@@ -123,14 +411,18 @@ func (q *queueImpl[X]) wait(who int, handler func(avail []X) int) bool {
 		}
 
 		consumed = min(consumed, len(toSend))
-		q.subs[who] = last + consumed // move past consumed
+		if len(sub.urgent) != 0 {
+			sub.urgent = sub.urgent[consumed:]
+		} else {
+			sub.pos += consumed // move past consumed
+		}
 		return true
 	}
 }
 
 type queueListener[X any] struct {
 	q   *queueImpl[X]
-	who int
+	sub *queueSub[X]
 }
 
 func (ql *queueListener[X]) Peek() (out X, ok bool) {
@@ -139,25 +431,25 @@ func (ql *queueListener[X]) Peek() (out X, ok bool) {
 	q.cond.L.Lock()
 	defer q.cond.L.Unlock()
 
-	var last int
-	last, ok = q.subs[ql.who]
-	if !ok {
+	avail := q.pending(ql.sub)
+	if len(avail) == 0 {
 		return
 	}
+	return avail[0], true
+}
 
-	ok = last < q.head
-	if !ok {
-		return
-	}
+func (ql *queueListener[X]) Next() (X, bool) {
+	return ql.NextCtx(context.Background())
+}
 
-	start := q.head - len(q.events)
-	skip := last - start
-	out = q.events[skip]
-	return
+func (ql *queueListener[X]) NextTimeout(d time.Duration) (X, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return ql.NextCtx(ctx)
 }
 
-func (ql *queueListener[X]) Next() (out X, ok bool) {
-	ql.q.wait(ql.who, func(avail []X) int {
+func (ql *queueListener[X]) NextCtx(ctx context.Context) (out X, ok bool) {
+	ql.q.wait(ctx, ql.sub, func(avail []X) int {
 		out = avail[0]
 		ok = true
 		return 1
@@ -167,9 +459,27 @@ func (ql *queueListener[X]) Next() (out X, ok bool) {
 
 func (ql *queueListener[X]) Batch() []X {
 	var out []X
-	ql.q.wait(ql.who, func(avail []X) int {
+	ql.q.wait(context.Background(), ql.sub, func(avail []X) int {
 		out = avail
 		return len(avail)
 	})
 	return out
 }
+
+func (ql *queueListener[X]) Lag() int {
+	q := ql.q
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return q.lag(ql.sub)
+}
+
+func (ql *queueListener[X]) Err() error {
+	q := ql.q
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return ql.sub.err
+}
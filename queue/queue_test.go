@@ -96,3 +96,312 @@ func TestPeek(t *testing.T) {
 		t.Errorf("got value when peeking at end: %v", value)
 	}
 }
+
+func TestLag(t *testing.T) {
+	q := New[int]()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	slow := q.Join(ctx)
+	fast := q.Join(context.Background())
+
+	q.Push(1, 2, 3)
+	fast.Batch()
+
+	if lag := slow.Lag(); lag != 3 {
+		t.Errorf("expected slow lag=3, was: %v", lag)
+	}
+	if lag := fast.Lag(); lag != 0 {
+		t.Errorf("expected fast lag=0, was: %v", lag)
+	}
+
+	s := q.Stats()
+	expected := Stats{Head: 3, Retained: 3, Listeners: 2, MaxLag: 3}
+	if s != expected {
+		t.Errorf("bad stats: was=%+v expected=%+v", s, expected)
+	}
+
+	slow.Next()
+	if lag := slow.Lag(); lag != 2 {
+		t.Errorf("expected slow lag=2, was: %v", lag)
+	}
+
+	cancel()
+	time.Sleep(time.Millisecond * 5)
+
+	if lag := slow.Lag(); lag != 0 {
+		t.Errorf("expected cancelled lag=0, was: %v", lag)
+	}
+	s = q.Stats()
+	expected = Stats{Head: 3, Retained: 0, Listeners: 1, MaxLag: 0}
+	if s != expected {
+		t.Errorf("bad stats after cancel: was=%+v expected=%+v", s, expected)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	q := NewConfig(Config[int]{History: 2})
+	q.Push(1, 2, 3)
+
+	l := q.JoinWithReplay(context.Background(), 5)
+	out := l.Batch()
+	if !reflect.DeepEqual(out, []int{2, 3}) {
+		t.Errorf("expected replay 2,3, was: %+v", out)
+	}
+
+	l2 := q.JoinWithReplay(context.Background(), 1)
+	q.Push(4)
+
+	out = l.Batch()
+	if !reflect.DeepEqual(out, []int{4}) {
+		t.Errorf("expected 4, was: %+v", out)
+	}
+	out = l2.Batch()
+	if !reflect.DeepEqual(out, []int{3, 4}) {
+		t.Errorf("expected replay 3,4, was: %+v", out)
+	}
+
+	plain := New[int]()
+	plain.Push(1, 2, 3)
+	if lag := plain.JoinWithReplay(context.Background(), 5).Lag(); lag != 0 {
+		t.Errorf("expected no replay without history, was: %v", lag)
+	}
+}
+
+func TestNextTimeout(t *testing.T) {
+	q := New[int]()
+	l := q.Join(context.Background())
+
+	value, ok := l.NextTimeout(time.Millisecond * 5)
+	if ok {
+		t.Errorf("expected timeout, got value: %v", value)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		q.Push(123)
+	}()
+	value, ok = l.NextTimeout(time.Second)
+	if value != 123 || !ok {
+		t.Errorf("expected 123 before timeout, was: %v", value)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.Push(456)
+	value, ok = l.NextCtx(ctx)
+	if value != 456 || !ok {
+		t.Errorf("expected available value despite done ctx, was: %v", value)
+	}
+}
+
+func TestClose(t *testing.T) {
+	q := New[int]()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := q.Join(ctx)
+	l := q.Join(context.Background())
+
+	cancel()
+	time.Sleep(time.Millisecond * 5)
+	if err := cancelled.Err(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, was: %v", err)
+	}
+	if err := l.Err(); err != nil {
+		t.Errorf("expected valid listener, was: %v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		q.Close(nil)
+	}()
+	if out := l.Batch(); len(out) != 0 {
+		t.Errorf("expected no events after close, was: %+v", out)
+	}
+	if err := l.Err(); err != ErrClosed {
+		t.Errorf("expected ErrClosed, was: %v", err)
+	}
+	if err := cancelled.Err(); err != context.Canceled {
+		t.Errorf("expected cancelled listener to keep its error, was: %v", err)
+	}
+
+	if q.Push(1) {
+		t.Errorf("expected push to closed queue to do nothing")
+	}
+	if err := q.Join(context.Background()).Err(); err != ErrClosed {
+		t.Errorf("expected join after close to be invalid, was: %v", err)
+	}
+	if q.Close(nil) {
+		t.Errorf("expected second close to return false")
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	type status struct {
+		who   string
+		value int
+	}
+	q := NewCoalesce(Config[status]{}, func(s status) string { return s.who })
+
+	fast := q.Join(context.Background())
+	slow := q.Join(context.Background())
+
+	q.Push(status{"a", 1}, status{"b", 1})
+	if value, _ := fast.Next(); value != (status{"a", 1}) {
+		t.Errorf("expected a=1, was: %+v", value)
+	}
+
+	q.Push(status{"a", 2}, status{"b", 2})
+
+	out := fast.Batch()
+	if !reflect.DeepEqual(out, []status{{"a", 2}, {"b", 2}}) {
+		t.Errorf("expected a=2,b=2 for fast, was: %+v", out)
+	}
+	out = slow.Batch()
+	if !reflect.DeepEqual(out, []status{{"a", 2}, {"b", 2}}) {
+		t.Errorf("expected a=2,b=2 for slow, was: %+v", out)
+	}
+
+	q.Push(status{"a", 3})
+	q.Push(status{"a", 4})
+	if lag := slow.Lag(); lag != 1 {
+		t.Errorf("expected lag=1 for slow, was: %v", lag)
+	}
+	if value, _ := slow.Next(); value != (status{"a", 4}) {
+		t.Errorf("expected a=4, was: %+v", value)
+	}
+
+	// same key within a single push
+	q.Push(status{"a", 5}, status{"b", 5}, status{"a", 6})
+	q.Push(status{"a", 7}, status{"a", 8})
+	out = slow.Batch()
+	if !reflect.DeepEqual(out, []status{{"b", 5}, {"a", 8}}) {
+		t.Errorf("expected b=5,a=8 for slow, was: %+v", out)
+	}
+
+	if s := q.Stats(); s.Head != 11 {
+		t.Errorf("expected head to count all pushed events, was: %+v", s)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	var evicted []int
+	var evictedListeners []Listener[int]
+	q := NewConfig(Config[int]{
+		MaxLag: 2,
+		OnEvict: func(l Listener[int], lag int) {
+			evicted = append(evicted, lag)
+			evictedListeners = append(evictedListeners, l)
+		},
+	})
+
+	slow := q.Join(context.Background())
+	fast := q.Join(context.Background())
+
+	q.Push(1, 2)
+	fast.Batch()
+	q.Push(3)
+	fast.Batch()
+	if len(evicted) != 0 {
+		t.Errorf("expected no evictions, was: %+v", evicted)
+	}
+
+	q.Push(4)
+	if !reflect.DeepEqual(evicted, []int{3}) {
+		t.Errorf("expected eviction with lag=3, was: %+v", evicted)
+	}
+	if len(evictedListeners) != 1 || evictedListeners[0] != slow {
+		t.Errorf("expected OnEvict to be passed the slow listener")
+	}
+	if err := slow.Err(); err != ErrEvicted {
+		t.Errorf("expected ErrEvicted, was: %v", err)
+	}
+	if out := slow.Batch(); len(out) != 0 {
+		t.Errorf("expected no events for evicted, was: %+v", out)
+	}
+	if s := q.Stats(); s.Retained != 1 || s.Listeners != 1 {
+		t.Errorf("expected evicted backlog dropped, was: %+v", s)
+	}
+
+	if out := fast.Batch(); !reflect.DeepEqual(out, []int{4}) {
+		t.Errorf("expected 4 for fast, was: %+v", out)
+	}
+}
+
+func TestEvictLargePush(t *testing.T) {
+	var evicted []int
+	q := NewConfig(Config[int]{
+		MaxLag:  2,
+		OnEvict: func(_ Listener[int], lag int) { evicted = append(evicted, lag) },
+	})
+
+	l := q.Join(context.Background())
+	q.Push(1, 2, 3) // larger than MaxLag, but l was caught up
+
+	if len(evicted) != 0 || l.Err() != nil {
+		t.Errorf("expected caught-up listener to survive, evicted=%+v err=%v", evicted, l.Err())
+	}
+	if out := l.Batch(); !reflect.DeepEqual(out, []int{1, 2, 3}) {
+		t.Errorf("expected 1,2,3, was: %+v", out)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	q := New[string]()
+	l := q.Join(context.Background())
+
+	q.Push("data1", "data2")
+	q.PushPriority(1, "low1")
+	q.PushPriority(2, "high")
+	q.PushPriority(1, "low2")
+
+	if lag := l.Lag(); lag != 5 {
+		t.Errorf("expected lag=5, was: %v", lag)
+	}
+	if value, _ := l.Peek(); value != "high" {
+		t.Errorf("expected peek high, was: %v", value)
+	}
+	if value, _ := l.Next(); value != "high" {
+		t.Errorf("expected high, was: %v", value)
+	}
+
+	out := l.Batch()
+	if !reflect.DeepEqual(out, []string{"low1", "low2"}) {
+		t.Errorf("expected low1,low2, was: %+v", out)
+	}
+	out = l.Batch()
+	if !reflect.DeepEqual(out, []string{"data1", "data2"}) {
+		t.Errorf("expected data1,data2, was: %+v", out)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		q.PushPriority(1, "wake")
+	}()
+	if value, ok := l.NextTimeout(time.Second); value != "wake" || !ok {
+		t.Errorf("expected priority push to wake listener, was: %v", value)
+	}
+}
+
+func TestEvictPriority(t *testing.T) {
+	var evicted []int
+	q := NewConfig(Config[int]{
+		MaxLag:  2,
+		OnEvict: func(_ Listener[int], lag int) { evicted = append(evicted, lag) },
+	})
+
+	l := q.Join(context.Background())
+	q.PushPriority(1, 1, 2, 3)
+	if len(evicted) != 0 {
+		t.Errorf("expected no evictions, was: %+v", evicted)
+	}
+
+	if q.PushPriority(1, 4) {
+		t.Errorf("expected no listeners after eviction")
+	}
+	if !reflect.DeepEqual(evicted, []int{3}) {
+		t.Errorf("expected eviction with lag=3, was: %+v", evicted)
+	}
+	if err := l.Err(); err != ErrEvicted {
+		t.Errorf("expected ErrEvicted, was: %v", err)
+	}
+}
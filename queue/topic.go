@@ -0,0 +1,142 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// NewTopic builds a new concurrent broadcast queue where events are keyed.
+func NewTopic[K comparable, X any]() TopicQueue[K, X] {
+	return &topicQueue[K, X]{q: newQueue(Config[topicEvent[K, X]]{})}
+}
+
+type topicEvent[K comparable, X any] struct {
+	key K
+	x   X
+}
+
+type topicQueue[K comparable, X any] struct {
+	q *queueImpl[topicEvent[K, X]]
+}
+
+func (tq *topicQueue[K, X]) Push(key K, all ...X) bool {
+	events := make([]topicEvent[K, X], len(all))
+	for i, x := range all {
+		events[i] = topicEvent[K, X]{key: key, x: x}
+	}
+	return tq.q.Push(events...)
+}
+
+func (tq *topicQueue[K, X]) Join(ctx context.Context, key K) Listener[X] {
+	return tq.JoinFilter(ctx, func(k K) bool { return k == key })
+}
+
+func (tq *topicQueue[K, X]) JoinFilter(ctx context.Context, filter func(K) bool) Listener[X] {
+	l := tq.q.join(ctx, 0)
+	return &topicListener[K, X]{q: tq.q, sub: l.sub, filter: filter}
+}
+
+func (tq *topicQueue[K, X]) Close(err error) bool {
+	return tq.q.Close(err)
+}
+
+func (tq *topicQueue[K, X]) Err() error {
+	return tq.q.Err()
+}
+
+func (tq *topicQueue[K, X]) Stats() Stats {
+	return tq.q.Stats()
+}
+
+type topicListener[K comparable, X any] struct {
+	q      *queueImpl[topicEvent[K, X]]
+	sub    *queueSub[topicEvent[K, X]]
+	filter func(K) bool
+}
+
+func (tl *topicListener[K, X]) Peek() (out X, ok bool) {
+	q := tl.q
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	avail := q.pending(tl.sub)
+	for _, e := range avail {
+		if tl.filter(e.key) {
+			return e.x, true
+		}
+	}
+	return
+}
+
+func (tl *topicListener[K, X]) Next() (X, bool) {
+	return tl.NextCtx(context.Background())
+}
+
+func (tl *topicListener[K, X]) NextTimeout(d time.Duration) (X, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return tl.NextCtx(ctx)
+}
+
+func (tl *topicListener[K, X]) NextCtx(ctx context.Context) (out X, ok bool) {
+	for !ok {
+		// consume up to and including the first match, or everything if nothing matches
+		valid := tl.q.wait(ctx, tl.sub, func(avail []topicEvent[K, X]) int {
+			for i, e := range avail {
+				if tl.filter(e.key) {
+					out = e.x
+					ok = true
+					return i + 1
+				}
+			}
+			return len(avail)
+		})
+		if !valid {
+			break
+		}
+	}
+	return out, ok
+}
+
+func (tl *topicListener[K, X]) Batch() []X {
+	var out []X
+	for len(out) == 0 {
+		valid := tl.q.wait(context.Background(), tl.sub, func(avail []topicEvent[K, X]) int {
+			for _, e := range avail {
+				if tl.filter(e.key) {
+					out = append(out, e.x)
+				}
+			}
+			return len(avail)
+		})
+		if !valid {
+			break
+		}
+	}
+	return out
+}
+
+func (tl *topicListener[K, X]) Lag() (count int) {
+	q := tl.q
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	avail := q.pending(tl.sub)
+	for _, e := range avail {
+		if tl.filter(e.key) {
+			count++
+		}
+	}
+	return count
+}
+
+func (tl *topicListener[K, X]) Err() error {
+	q := tl.q
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return tl.sub.err
+}
@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestTopic(t *testing.T) {
+	q := NewTopic[string, int]()
+
+	a := q.Join(context.Background(), "a")
+	all := q.JoinFilter(context.Background(), func(string) bool { return true })
+
+	q.Push("b", 1, 2)
+	q.Push("a", 3)
+	q.Push("b", 4)
+	q.Push("a", 5)
+
+	if lag := a.Lag(); lag != 2 {
+		t.Errorf("expected lag=2 for a, was: %v", lag)
+	}
+	if value, ok := a.Peek(); value != 3 || !ok {
+		t.Errorf("expected peek 3 for a, was: %v", value)
+	}
+
+	out := a.Batch()
+	if !reflect.DeepEqual(out, []int{3, 5}) {
+		t.Errorf("expected 3,5 for a, was: %+v", out)
+	}
+
+	if value, ok := all.Next(); value != 1 || !ok {
+		t.Errorf("expected 1 for all, was: %v", value)
+	}
+	out = all.Batch()
+	if !reflect.DeepEqual(out, []int{2, 3, 4, 5}) {
+		t.Errorf("expected 2,3,4,5 for all, was: %+v", out)
+	}
+
+	q.Push("c", 6) // trims consumed events
+	if s := q.Stats(); s.Retained != 1 {
+		t.Errorf("expected shared trim of consumed events, was: %+v", s)
+	}
+}
@@ -2,17 +2,56 @@ package queue
 
 import (
 	"context"
+	"time"
 )
 
+// Config is passed to NewConfig.
+type Config[X any] struct {
+	// History is the number of recent events to retain even if no listener needs them.
+	// This allows JoinWithReplay to provide recent events to late joiners.
+	History int
+
+	// MaxLag, if positive, evicts any listener which already has more than this many pending events when Push or PushPriority is called.
+	// Evicted listeners become invalid with ErrEvicted, and their backlog is no longer retained.
+	MaxLag int
+
+	// OnEvict is called with each listener evicted due to MaxLag, along with its lag at eviction.
+	// The listener is the same value returned from Join, so owners can find and act on whatever was using it.
+	// It is called after Push or PushPriority has released its lock.
+	OnEvict func(l Listener[X], lag int)
+}
+
 type Queue[X any] interface {
 	// Push adds more events to the queue.
 	// All subscribers currently waiting will recieve at least one event before this method returns.
 	// Returns true if any subscribers woke up.
 	Push(all ...X) bool
 
+	// PushPriority adds events which current listeners receive before any normal pending events.
+	// Higher priorities are received first, and events of the same priority are received in order.
+	// A priority of zero or less is the same as Push.
+	// Priority events are only given to current listeners and are never retained, coalesced or replayed.
+	// Returns true if there were any listeners.
+	PushPriority(priority int, all ...X) bool
+
 	// Join returns a listener that provides all events passed with Push after this call completes.
 	// If the context is cancelled, the listener becomes invalid and returns no/empty values.
 	Join(ctx context.Context) Listener[X]
+
+	// JoinWithReplay is as Join, but the listener first receives up to n of the most recent retained events.
+	// Events are retained while any other listener has yet to consume them, or up to Config.History.
+	JoinWithReplay(ctx context.Context, n int) Listener[X]
+
+	// Close shuts down this queue, invalidating all listeners with the given error (or ErrClosed if nil).
+	// Future calls to Push are ignored, and future listeners are immediately invalid.
+	// Returns false if the queue was already closed.
+	Close(err error) bool
+
+	// Err returns nil while this queue is open, or the error it was closed with.
+	Err() error
+
+	// Stats returns a snapshot of this queue's current state.
+	Stats() Stats
 }
 
 type Listener[X any] interface {
@@ -25,7 +64,53 @@ type Listener[X any] interface {
 	// It returns the zero X and false if this listener is invalid/cancelled context.
 	Next() (X, bool)
 
+	// NextCtx is as Next, but also returns the zero X and false if the passed context is done before an event is available.
+	// This does not invalidate the listener.
+	NextCtx(ctx context.Context) (X, bool)
+
+	// NextTimeout is as NextCtx, but waits for at most the given duration.
+	NextTimeout(d time.Duration) (X, bool)
+
 	// Batch waits for and returns a slice of all available queue events.
 	// If the returned slice is nil or has zero length, this listener is invalid/cancelled context.
 	Batch() []X
+
+	// Lag returns the number of events pending for this listener.
+	// It returns zero if this listener is invalid.
+	Lag() int
+
+	// Err returns nil while this listener is valid.
+	// Otherwise, it returns the cause of its cancelled context, or the error passed to Queue.Close.
+	Err() error
+}
+
+// Stats is a snapshot of a Queue's state.
+type Stats struct {
+	Head      int // total number of events ever pushed
+	Retained  int // number of events currently held for listeners
+	Listeners int // number of active listeners
+	MaxLag    int // number of events pending for the slowest listener
+}
+
+// TopicQueue is a broadcast queue where every event has a key.
+// Listeners only receive events whose key matches, but all events are retained in shared storage.
+type TopicQueue[K comparable, X any] interface {
+	// Push adds more events to the queue under the given key.
+	// Returns true if any subscribers woke up, even if they were not interested in this key.
+	Push(key K, all ...X) bool
+
+	// Join returns a listener that provides events passed with Push for the given key.
+	Join(ctx context.Context, key K) Listener[X]
+
+	// JoinFilter returns a listener that provides events passed with Push where the filter returns true for their key.
+	JoinFilter(ctx context.Context, filter func(K) bool) Listener[X]
+
+	// Close shuts down this queue, as per Queue.Close.
+	Close(err error) bool
+
+	// Err returns nil while this queue is open, or the error it was closed with.
+	Err() error
+
+	// Stats returns a snapshot of this queue's current state, across all keys.
+	Stats() Stats
 }
@@ -7,6 +7,7 @@ import (
 	"log"
 	"mime"
 	"net/http"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -105,6 +106,8 @@ func (c *ServeFs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cacheForever := false
 
 	if !serve404 {
+		policy := c.cachePolicy(r.URL.Path)
+
 		effectiveHash = info.ContentHash
 		if queryHash := GetQueryHash(r.URL.RawQuery); queryHash != "" {
 			effectiveHash = queryHash
@@ -121,8 +124,8 @@ func (c *ServeFs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			head.Set("ETag", effectiveHash)
 			notModified = r.Header.Get("If-None-Match") == effectiveHash
 
-			// we had a url-based-hash, cache forever
-			if cacheForever {
+			// we had a url-based-hash, cache forever (unless there's a policy)
+			if cacheForever && policy == nil {
 				head.Set("Cache-Control", "public, max-age=7776000, immutable")
 			}
 
@@ -134,6 +137,12 @@ func (c *ServeFs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
+
+		// explicit policies win over hash-based caching
+		if policy != nil {
+			head.Set("Cache-Control", policy.header())
+			cacheForever = policy.Immutable && policy.MaxAge > 0 && !policy.NoStore
+		}
 	}
 
 	if c.UpdateHeader != nil {
@@ -178,3 +187,28 @@ func (c *ServeFs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "<!--:%s:-->\n", effectiveHash)
 	}
 }
+
+// cachePolicy returns the first CachePolicy matching the given path, or nil.
+func (c *ServeFs) cachePolicy(p string) *CachePolicy {
+	for i := range c.CachePolicies {
+		policy := &c.CachePolicies[i]
+		if ok, _ := path.Match(policy.PathGlob, p); ok {
+			return policy
+		}
+	}
+	return nil
+}
+
+// header returns the Cache-Control header for this policy.
+func (policy *CachePolicy) header() string {
+	if policy.NoStore {
+		return "no-store"
+	} else if policy.MaxAge <= 0 {
+		return "no-cache"
+	}
+	out := fmt.Sprintf("public, max-age=%d", int64(policy.MaxAge.Seconds()))
+	if policy.Immutable {
+		out += ", immutable"
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package static
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testContent map[string]string
+
+func (tc testContent) Get(path string) (*FileInfo, io.ReadCloser) {
+	s, ok := tc[path]
+	if !ok {
+		return nil, nil
+	}
+	return &FileInfo{}, io.NopCloser(strings.NewReader(s))
+}
+
+func (tc testContent) Exists(path string) bool {
+	_, ok := tc[path]
+	return ok
+}
+
+func TestCachePolicies(t *testing.T) {
+	var lastInfo ServeInfo
+	s := &ServeFs{
+		Content: testContent{
+			"sw.js":                  "// service worker",
+			"manifest.json":          "{}",
+			"store.js":               "// store",
+			"plain.js":               "// plain",
+			"query.js":               "// query",
+			"fonts/a.woff2":          "font",
+			"assets/app-abc123.js":   "// app",
+			"assets/other-abc123.js": "// other",
+		},
+		CachePolicies: []CachePolicy{
+			{PathGlob: "/sw.js"},
+			{PathGlob: "/assets/other-*.js", MaxAge: time.Hour},
+			{PathGlob: "/manifest.json", NoStore: true},
+			{PathGlob: "/store.js", NoStore: true, MaxAge: time.Hour, Immutable: true},
+			{PathGlob: "/fonts/*", MaxAge: time.Hour * 24, Immutable: true},
+		},
+		UpdateHeader: func(h http.Header, si ServeInfo) { lastInfo = si },
+	}
+
+	type expected struct {
+		cacheControl string
+		cacheForever bool
+	}
+	tests := map[string]expected{
+		"/sw.js":                  {"no-cache", false},
+		"/assets/app-abc123.js":   {"public, max-age=7776000, immutable", true},
+		"/assets/other-abc123.js": {"public, max-age=3600", false}, // policy wins over file hash
+		"/manifest.json?abcdef12": {"no-store", false},             // policy wins over query hash
+		"/store.js":               {"no-store", false},             // NoStore wins over other fields
+		"/fonts/a.woff2":          {"public, max-age=86400, immutable", true},
+		"/plain.js":               {"", false},
+		"/query.js?abcdef12":      {"public, max-age=7776000, immutable", true},
+	}
+	for p, e := range tests {
+		lastInfo = ServeInfo{}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+		if got := w.Header().Get("Cache-Control"); got != e.cacheControl {
+			t.Errorf("bad Cache-Control for %v: was=%v expected=%v", p, got, e.cacheControl)
+		}
+		if lastInfo.CacheForever != e.cacheForever {
+			t.Errorf("bad CacheForever for %v: was=%v expected=%v", p, lastInfo.CacheForever, e.cacheForever)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package static
 import (
 	"io"
 	"net/http"
+	"time"
 )
 
 // FileInfo is returned from Content.
@@ -27,6 +28,21 @@ type ServeInfo struct {
 	CacheForever bool
 }
 
+// CachePolicy controls the Cache-Control header for requests matching PathGlob.
+type CachePolicy struct {
+	// PathGlob is matched against the request path with path.Match, e.g., "/sw.js" or "/assets/*.png".
+	PathGlob string
+
+	// MaxAge allows caching for this long. If zero, "no-cache" is sent.
+	MaxAge time.Duration
+
+	// Immutable marks the response as unchanging for MaxAge.
+	Immutable bool
+
+	// NoStore sends "no-store", ignoring the other fields.
+	NoStore bool
+}
+
 // Content controls what is rendered inside ServeFs.
 type Content interface {
 	Get(path string) (*FileInfo, io.ReadCloser)
@@ -53,6 +69,10 @@ type ServeFs struct {
 	// InsertHtmlHash controls whether a short `<!--:<hash>:-->` is added to each served HTML page.
 	InsertHtmlHash bool
 
+	// CachePolicies are checked in order against the request path, and the first match sets Cache-Control.
+	// This is used instead of the default hash-based caching, which may mark hashed files as immutable.
+	CachePolicies []CachePolicy
+
 	// UpdateHeader may be provided to update the headers of returned responses. Useful for CSP.
 	UpdateHeader func(http.Header, ServeInfo)
 }